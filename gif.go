@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// gifTranscodeThreshold is the raw upload size above which an animated GIF
+// is transcoded to MP4 instead of re-encoded as a (much larger) resized
+// GIF.
+const gifTranscodeThreshold = 5 * 1024 * 1024 // 5 MiB
+
+// isGIF reports whether the upload should go through the GIF pipeline,
+// checking both the extension and a content sniff so a mislabeled upload
+// still gets frame-preserving treatment.
+func isGIF(ext string, raw []byte) bool {
+	if strings.ToLower(ext) == ".gif" {
+		return true
+	}
+	return http.DetectContentType(raw) == "image/gif"
+}
+
+// optimizeGIF resizes an animated GIF while preserving per-frame delay.
+// GIFs above gifTranscodeThreshold are transcoded to MP4 instead, since a
+// resized GIF of that size is still large and MP4 plays it back far more
+// cheaply.
+//
+// gif.DecodeAll's frames are sub-rectangles of the logical canvas (most
+// real-world animated GIFs only redraw the part of the canvas that changed
+// each tick), not always full-canvas images. Resizing each frame
+// independently would scale that sub-rectangle as if it were the whole
+// image, dropping its offset and corrupting the animation. Instead, each
+// frame is composited onto a full-canvas accumulator per its disposal
+// method exactly as a GIF player would, and the composited (always
+// full-canvas) result is what gets resized and re-encoded, quantized
+// against the source GIF's own color table (see gifPalette) rather than a
+// fixed generic one, to avoid unnecessary banding on re-encode.
+func optimizeGIF(raw []byte, destKey string) (string, error) {
+	if len(raw) > gifTranscodeThreshold {
+		return transcodeGIFToMP4(raw, destKey)
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+
+	maxWidth, maxHeight := 800, 800
+	canvas := image.NewNRGBA(image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height))
+	resizedFrames := make([]*image.Paletted, len(decoded.Image))
+
+	// Re-quantizing to a fixed generic palette loses fidelity real GIFs
+	// don't need to lose. Most encoders share one color table across every
+	// frame (a global table, or every frame's local table being identical),
+	// so that table already covers every color the composited canvas can
+	// produce - prefer it. A GIF whose frames carry different local tables is
+	// still quantized against the first frame's table; the generic palette is
+	// used only when the source has no table at all.
+	outPalette := gifPalette(decoded)
+
+	for i, frame := range decoded.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		composed := image.NewNRGBA(canvas.Bounds())
+		draw.Draw(composed, composed.Bounds(), canvas, image.Point{}, draw.Src)
+
+		resized := imaging.Fit(composed, maxWidth, maxHeight, imaging.Box)
+		paletted := image.NewPaletted(resized.Bounds(), outPalette)
+		draw.Draw(paletted, paletted.Bounds(), resized, resized.Bounds().Min, draw.Src)
+		resizedFrames[i] = paletted
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, canvas.Bounds(), previous, image.Point{}, draw.Src)
+		}
+	}
+
+	decoded.Image = resizedFrames
+	for i := range decoded.Disposal {
+		// Frames are now always full-canvas composites, so there's no
+		// partial region left for a disposal method to act on.
+		decoded.Disposal[i] = gif.DisposalNone
+	}
+	if len(resizedFrames) > 0 {
+		b := resizedFrames[0].Bounds()
+		decoded.Config.Width = b.Dx()
+		decoded.Config.Height = b.Dy()
+	}
+
+	encoded := new(bytes.Buffer)
+	if err := gif.EncodeAll(encoded, decoded); err != nil {
+		return "", err
+	}
+
+	if err := storage.PutFile(destKey, encoded); err != nil {
+		return "", err
+	}
+	return destKey, nil
+}
+
+// gifPalette picks the color table to quantize the resized/composited
+// frames against. It prefers the source GIF's own table - the global table
+// if one is set, otherwise the first frame's local table, since encoders
+// overwhelmingly reuse one table for every frame - and only falls back to a
+// fixed generic palette when the source has no usable table at all.
+func gifPalette(decoded *gif.GIF) color.Palette {
+	if gp, ok := decoded.Config.ColorModel.(color.Palette); ok && len(gp) > 0 {
+		return gp
+	}
+	if len(decoded.Image) > 0 && len(decoded.Image[0].Palette) > 0 {
+		return decoded.Image[0].Palette
+	}
+	return palette.Plan9
+}
+
+// transcodeGIFToMP4 shells out to ffmpeg, since Go has no video encoder in
+// its standard toolbox. The source GIF and resulting MP4 are round-tripped
+// through a temp directory because ffmpeg only speaks files.
+func transcodeGIFToMP4(raw []byte, destKey string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "gif-transcode")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcPath := filepath.Join(tmpDir, "src.gif")
+	if err := os.WriteFile(srcPath, raw, 0644); err != nil {
+		return "", err
+	}
+
+	outPath := filepath.Join(tmpDir, "out.mp4")
+	cmd := exec.Command("ffmpeg", "-y", "-i", srcPath, "-movflags", "faststart", "-pix_fmt", "yuv420p", outPath)
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	outFile, err := os.Open(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
+
+	mp4Key := variantPath(destKey, ".mp4")
+	if err := storage.PutFile(mp4Key, outFile); err != nil {
+		return "", err
+	}
+	return mp4Key, nil
+}
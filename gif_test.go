@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// TestOptimizeGIFPreservesFrameOffsets reproduces a delta-frame animated
+// GIF (a later frame is a small sub-rectangle patch, not a full-canvas
+// image) and checks that resizing doesn't drop the patch's offset.
+func TestOptimizeGIFPreservesFrameOffsets(t *testing.T) {
+	storage = newStorageFS(t.TempDir())
+
+	const canvasSize = 1600
+	pal := color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}}
+
+	background := image.NewPaletted(image.Rect(0, 0, canvasSize, canvasSize), pal)
+	for i := range background.Pix {
+		background.Pix[i] = 0 // black
+	}
+
+	// A red patch offset to the (800,800)-(1200,1200) quadrant, the kind of
+	// sub-rectangle delta frame real animated GIF encoders emit.
+	patchBounds := image.Rect(800, 800, 1200, 1200)
+	patch := image.NewPaletted(patchBounds, pal)
+	for i := range patch.Pix {
+		patch.Pix[i] = 2 // red
+	}
+
+	src := &gif.GIF{
+		Image:    []*image.Paletted{background, patch},
+		Delay:    []int{10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone},
+		Config:   image.Config{Width: canvasSize, Height: canvasSize},
+	}
+
+	raw := new(bytes.Buffer)
+	if err := gif.EncodeAll(raw, src); err != nil {
+		t.Fatalf("failed to encode fixture GIF: %v", err)
+	}
+
+	key, err := optimizeGIF(raw.Bytes(), "animated.gif")
+	if err != nil {
+		t.Fatalf("optimizeGIF returned error: %v", err)
+	}
+
+	out, err := storage.GetFile(key)
+	if err != nil {
+		t.Fatalf("failed to read optimized GIF: %v", err)
+	}
+	defer out.Close()
+
+	decoded, err := gif.DecodeAll(out)
+	if err != nil {
+		t.Fatalf("failed to decode optimized GIF: %v", err)
+	}
+	if len(decoded.Image) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(decoded.Image))
+	}
+
+	// The 1600->800 resize is a 0.5 scale, so the patch that was at
+	// (800,800)-(1200,1200) on the original canvas should land at
+	// (400,400)-(600,600) on the resized one, not fill the whole resized
+	// canvas (which is what resizing the 400x400 sub-rectangle in place,
+	// ignoring its offset, would produce).
+	frame := decoded.Image[1]
+
+	r, g, bl, _ := frame.At(500, 500).RGBA()
+	if r < 0x8000 || g > 0x4000 || bl > 0x4000 {
+		t.Fatalf("expected red at patch center (500,500), got rgba=(%d,%d,%d)", r, g, bl)
+	}
+
+	r, g, bl, _ = frame.At(100, 100).RGBA()
+	if r > 0x4000 || g > 0x4000 || bl > 0x4000 {
+		t.Fatalf("expected background black outside the patch at (100,100), got rgba=(%d,%d,%d) - the patch offset was dropped", r, g, bl)
+	}
+}
+
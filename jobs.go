@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// JobStatus tracks where an ImageJob is in the pipeline. The same values
+// are mirrored onto Product.ImageStatus so a client can check either the
+// job or the product it belongs to.
+type JobStatus string
+
+const (
+	JobPending    JobStatus = "pending"
+	JobProcessing JobStatus = "processing"
+	JobDone       JobStatus = "done"
+	JobFailed     JobStatus = "failed"
+	JobDuplicate  JobStatus = "duplicate"
+)
+
+// ImageJob describes one queued optimizeImage run. SrcKey is the
+// already-persisted raw upload; DestKey is where the optimized result (and
+// its sidecars) get written, overwriting SrcKey when they're the same key.
+// SkipDupCheck turns off the duplicate check in processImageJob.
+// createProduct always sets it, since it runs the check itself (or skips it
+// on ?force=true) before enqueueing; tus uploads leave it unset.
+type ImageJob struct {
+	ID           string
+	SrcKey       string
+	DestKey      string
+	Ext          string
+	ProductID    uint
+	SkipDupCheck bool
+}
+
+// jobRecord is the pollable state behind GET /jobs/:id.
+type jobRecord struct {
+	ID             string    `json:"id"`
+	ProductID      uint      `json:"product_id"`
+	Status         JobStatus `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	DuplicateOfIDs []uint    `json:"duplicate_of_ids,omitempty"`
+}
+
+var (
+	jobsMu sync.RWMutex
+	jobs   = make(map[string]*jobRecord)
+)
+
+// imageJobQueue is the buffered channel worker goroutines read from.
+// It's sized and populated by startImageWorkers.
+var imageJobQueue chan ImageJob
+
+var imageWorkersWG sync.WaitGroup
+
+// startImageWorkers launches size worker goroutines pulling from a
+// queue buffered queueSize deep, and returns the queue so callers can
+// enqueue jobs and close it for a graceful shutdown.
+func startImageWorkers(size, queueSize int) chan ImageJob {
+	queue := make(chan ImageJob, queueSize)
+	for i := 0; i < size; i++ {
+		imageWorkersWG.Add(1)
+		go func(workerID int) {
+			defer imageWorkersWG.Done()
+			for job := range queue {
+				processImageJob(job)
+			}
+		}(i)
+	}
+	return queue
+}
+
+// enqueueImageJob records a pending job and hands it to the worker pool.
+func enqueueImageJob(job ImageJob) {
+	jobsMu.Lock()
+	jobs[job.ID] = &jobRecord{ID: job.ID, ProductID: job.ProductID, Status: JobPending}
+	jobsMu.Unlock()
+
+	imageJobQueue <- job
+}
+
+// processImageJob runs the existing optimizeImage pipeline against the
+// raw upload a worker pulled off the queue, then reflects the outcome onto
+// both the job record and the owning Product.
+//
+// The perceptual hash stored on the product comes from the decode
+// optimizeImage already does to resize the image. The duplicate check here
+// runs only for jobs without SkipDupCheck (uploads finished through tus);
+// createProduct checks for duplicates itself before enqueueing.
+func processImageJob(job ImageJob) {
+	setJobStatus(job.ID, JobProcessing, "", nil)
+	updateProductImageStatus(job.ProductID, JobProcessing)
+
+	raw, err := storage.GetFile(job.SrcKey)
+	if err != nil {
+		log.Printf("image job %s: failed to read raw upload %s: %v", job.ID, job.SrcKey, err)
+		setJobStatus(job.ID, JobFailed, err.Error(), nil)
+		updateProductImageStatus(job.ProductID, JobFailed)
+		return
+	}
+	defer raw.Close()
+
+	storedKey, phash, err := optimizeImage(raw, job.DestKey, job.Ext)
+	if err != nil {
+		log.Printf("image job %s: optimize failed: %v", job.ID, err)
+		setJobStatus(job.ID, JobFailed, err.Error(), nil)
+		updateProductImageStatus(job.ProductID, JobFailed)
+		return
+	}
+
+	updates := map[string]interface{}{"p_hash": phash}
+	// optimizeImage may return a different key than it was given, e.g. a
+	// large animated GIF transcoded to MP4.
+	if storedKey != job.DestKey {
+		updates["image_path"] = storedKey
+	}
+	if err := db.Model(&Product{}).Where("id = ?", job.ProductID).Updates(updates).Error; err != nil {
+		log.Printf("image job %s: failed to update product %d: %v", job.ID, job.ProductID, err)
+	}
+
+	// phash is 0 for the GIF/video pipeline, which doesn't compute one, so
+	// there's nothing to dedup against.
+	if phash != 0 && !job.SkipDupCheck {
+		duplicates, err := findSimilarProducts(phash, job.ProductID)
+		if err != nil {
+			log.Printf("image job %s: duplicate check failed: %v", job.ID, err)
+		} else if len(duplicates) > 0 {
+			ids := make([]uint, len(duplicates))
+			for i, d := range duplicates {
+				ids[i] = d.ID
+			}
+			setJobStatus(job.ID, JobDuplicate, "", ids)
+			updateProductImageStatus(job.ProductID, JobDuplicate)
+			return
+		}
+	}
+
+	setJobStatus(job.ID, JobDone, "", nil)
+	updateProductImageStatus(job.ProductID, JobDone)
+}
+
+func setJobStatus(id string, status JobStatus, errMsg string, duplicateOfIDs []uint) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if rec, ok := jobs[id]; ok {
+		rec.Status = status
+		rec.Error = errMsg
+		rec.DuplicateOfIDs = duplicateOfIDs
+	}
+}
+
+func updateProductImageStatus(productID uint, status JobStatus) {
+	if err := db.Model(&Product{}).Where("id = ?", productID).Update("image_status", string(status)).Error; err != nil {
+		log.Printf("image job: failed to update product %d status to %s: %v", productID, status, err)
+	}
+}
+
+// getJob handles GET /jobs/:id.
+func getJob(c *gin.Context) {
+	id := c.Param("id")
+
+	jobsMu.RLock()
+	rec, ok := jobs[id]
+	jobsMu.RUnlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+func newJobID() string {
+	return uuid.New().String()
+}
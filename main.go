@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -10,10 +11,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/disintegration/imaging"
@@ -32,12 +35,18 @@ type Product struct {
 	Description string    `json:"description"`
 	Price       float64   `json:"price" binding:"required"`
 	ImagePath   string    `json:"image_path"`
+	ImageStatus string    `json:"image_status" gorm:"default:''"`
+	PHash       uint64    `json:"p_hash" gorm:"column:p_hash;index"`
 	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
 var db *gorm.DB
 
+// storage is the configured ObjectStorage backend, selected once at
+// startup by newObjectStorage.
+var storage ObjectStorage
+
 // Memory pool for byte buffers to reduce GC pressure
 var bufferPool = sync.Pool{
 	New: func() interface{} {
@@ -52,11 +61,22 @@ func main() {
 	// Initialize database
 	initDB()
 
-	// Ensure upload directory exists
-	uploadDir := "./uploads"
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	// Select the object storage backend (local disk unless MINIO_URL is set)
+	storage = newObjectStorage()
+
+	// On-the-fly image variant disk cache (GET /uploads/:filename?w=...)
+	variants = newVariantCache(getEnv("VARIANT_CACHE_DIR", variantCacheDirDefault), variantCacheMaxBytes)
+
+	// Start the image optimization worker pool
+	workerCount, _ := strconv.Atoi(getEnv("IMAGE_WORKERS", "4"))
+	if workerCount < 1 {
+		workerCount = 4
 	}
+	queueSize, _ := strconv.Atoi(getEnv("IMAGE_QUEUE_SIZE", "100"))
+	if queueSize < 1 {
+		queueSize = 100
+	}
+	imageJobQueue = startImageWorkers(workerCount, queueSize)
 
 	// Set up Gin router
 	router := gin.Default()
@@ -71,25 +91,62 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Serve static files
-	router.Static("/uploads", uploadDir)
+	// Serve uploads from whichever backend is configured, transparently
+	// negotiating WebP/AVIF sidecars
+	router.GET("/uploads/*filepath", serveUpload)
 	router.MaxMultipartMemory = 8 << 20 // 8 MiB
 
+	// tus.io resumable upload protocol, for clients that can't risk a
+	// single multipart POST over a flaky connection
+	router.OPTIONS("/uploads/tus", tusOptions)
+	router.POST("/uploads/tus", tusCreate)
+	router.HEAD("/uploads/tus/:id", tusHead)
+	router.PATCH("/uploads/tus/:id", tusPatch)
+
 	// API routes
 	router.POST("/products", createProduct)
 	router.GET("/products", getProducts)
 	router.GET("/products/:id", getProduct)
+	router.GET("/products/similar/:id", getSimilarProducts)
 	router.PUT("/products/:id", updateProduct)
 	router.DELETE("/products/:id", deleteProduct)
 
+	// Poll the status of a queued image optimization job
+	router.GET("/jobs/:id", getJob)
+
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	// Start server on port 3006
-	log.Println("Server starting on port 3006...")
-	router.Run(":3006")
+	srv := &http.Server{
+		Addr:    ":3006",
+		Handler: router,
+	}
+
+	go func() {
+		log.Println("Server starting on port 3006...")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then shut down the HTTP server and drain the
+	// image job queue before exiting.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+
+	close(imageJobQueue)
+	imageWorkersWG.Wait()
+	log.Println("Image job queue drained, exiting")
 }
 
 func initDB() {
@@ -184,49 +241,98 @@ func createProduct(c *gin.Context) {
 		Price:       price,
 	}
 	
-	// Handle image upload if present
+	// Handle image upload if present: persist the raw upload immediately and
+	// hand resize/encode off to the worker pool so the request doesn't block
+	// on optimizeImage. The duplicate check still runs synchronously here -
+	// only on the cheap decode-for-hashing, not the resize/encode/store that
+	// optimizeImage does - so the 409 contract below holds without
+	// reintroducing the blocking cost chunk0-3 removed from this path.
 	files := form.File["image"]
-	if len(files) > 0 {
+	var key, ext string
+	var phash uint64
+	hasImage := len(files) > 0
+	if hasImage {
 		file := files[0]
-		
-		
-		// Generate unique filename
-		ext := filepath.Ext(file.Filename)
-		filename := uuid.New().String() + ext
-		imagePath := filepath.Join("uploads", filename)
-		
-		// Process and save the image
+
+		ext = filepath.Ext(file.Filename)
+		key = uuid.New().String() + ext
+
 		src, err := file.Open()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
 			return
 		}
-		defer src.Close()
-		
-		// Process image (resize and compress)
-		optimizedImagePath, err := optimizeImage(src, imagePath, ext)
+		raw, err := io.ReadAll(src)
+		src.Close()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
 			return
 		}
-		
-		// Update product with image path
-		product.ImagePath = optimizedImagePath
+
+		// The GIF/video pipeline never computes a hash (image.Decode would
+		// flatten an animated GIF to one frame), so there's nothing to
+		// dedup an animated upload against.
+		if !isGIF(ext, raw) {
+			if imgSrc, _, err := image.Decode(bytes.NewReader(raw)); err == nil {
+				phash = computePHash(imgSrc)
+			}
+		}
+
+		if phash != 0 && c.Query("force") != "true" {
+			duplicates, err := findSimilarProducts(phash, 0)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for duplicate images"})
+				return
+			}
+			if len(duplicates) > 0 {
+				ids := make([]uint, len(duplicates))
+				for i, d := range duplicates {
+					ids[i] = d.ID
+				}
+				c.JSON(http.StatusConflict, gin.H{
+					"error":                 "A matching product image already exists",
+					"duplicate_product_ids": ids,
+				})
+				return
+			}
+		}
+
+		if err := storage.PutFile(key, bytes.NewReader(raw)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save uploaded file"})
+			return
+		}
+
+		product.ImagePath = key
+		product.ImageStatus = string(JobPending)
+		product.PHash = phash
 	}
-	
+
 	// Save to database
 	result := db.Create(&product)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
-	
+
 	// Log performance
 	elapsed := time.Since(startTime).Milliseconds()
 	log.Printf("Product created in %dms", elapsed)
-	
-	// Return created product
-	c.JSON(http.StatusCreated, product)
+
+	if !hasImage {
+		c.JSON(http.StatusCreated, product)
+		return
+	}
+
+	jobID := newJobID()
+	// The duplicate check above (or an explicit ?force=true) already
+	// settled this product's dup status, so the async job never needs to
+	// redo it.
+	enqueueImageJob(ImageJob{ID: jobID, SrcKey: key, DestKey: key, Ext: ext, ProductID: product.ID, SkipDupCheck: true})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"product": product,
+		"job_id":  jobID,
+	})
 }
 
 // Get all products with pagination
@@ -328,17 +434,18 @@ func updateProduct(c *gin.Context) {
 		// Delete old image if exists
 		if product.ImagePath != "" {
 			// Delete in goroutine to not block the response
-			oldPath := product.ImagePath
+			oldKey := product.ImagePath
 			go func() {
-				os.Remove(oldPath)
+				storage.DeleteFile(oldKey)
+				storage.DeleteFile(variantPath(oldKey, ".webp"))
+				storage.DeleteFile(variantPath(oldKey, ".avif"))
 			}()
 		}
-		
-		// Generate unique filename
+
+		// Generate a unique bucket-relative key
 		ext := filepath.Ext(file.Filename)
-		filename := uuid.New().String() + ext
-		imagePath := filepath.Join("uploads", filename)
-		
+		key := uuid.New().String() + ext
+
 		// Process and save the image
 		src, err := file.Open()
 		if err != nil {
@@ -346,18 +453,22 @@ func updateProduct(c *gin.Context) {
 			return
 		}
 		defer src.Close()
-		
+
 		// Process image (resize and compress)
-		optimizedImagePath, err := optimizeImage(src, imagePath, ext)
+		storedKey, phash, err := optimizeImage(src, key, ext)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
 			return
 		}
-		
-		// Update product with new image path
-		product.ImagePath = optimizedImagePath
+
+		// Update product with the new storage key and the replacement
+		// image's hash, so duplicate detection doesn't keep comparing
+		// against the hash of the image that was just replaced.
+		product.ImagePath = storedKey
+		product.PHash = phash
+		product.ImageStatus = string(JobDone)
 	}
-	
+
 	// Save updates to database
 	if err := db.Save(&product).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
@@ -384,9 +495,11 @@ func deleteProduct(c *gin.Context) {
 	
 	// Delete image file if exists in a goroutine
 	if product.ImagePath != "" {
-		imagePath := product.ImagePath
+		key := product.ImagePath
 		go func() {
-			os.Remove(imagePath)
+			storage.DeleteFile(key)
+			storage.DeleteFile(variantPath(key, ".webp"))
+			storage.DeleteFile(variantPath(key, ".avif"))
 		}()
 	}
 	
@@ -399,89 +512,101 @@ func deleteProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
-// Helper function to optimize image by resizing and compressing
-func optimizeImage(src io.Reader, destPath string, ext string) (string, error) {
+// optimizeResult is what the background goroutine in optimizeImage reports
+// back: the stored key, the dHash of the decoded image (0 for the GIF/video
+// pipeline, which doesn't compute one), and any error.
+type optimizeResult struct {
+	path  string
+	phash uint64
+	err   error
+}
+
+// Helper function to optimize image by resizing and compressing. destPath
+// is treated as a bucket-relative key into the configured ObjectStorage
+// backend, not a filesystem path. The returned hash is the dHash of the
+// decoded source image, computed from the same decode the resize already
+// pays for, so callers can run duplicate detection without a second decode.
+func optimizeImage(src io.Reader, destPath string, ext string) (string, uint64, error) {
 	// Get buffer from pool
 	buffer := bufferPool.Get().(*bytes.Buffer)
 	buffer.Reset()
 	defer bufferPool.Put(buffer)
-	
+
 	// Read the image
 	_, err := io.Copy(buffer, src)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	
+
 	// Create a channel for results
-	resultCh := make(chan struct {
-		path string
-		err  error
-	})
-	
+	resultCh := make(chan optimizeResult)
+
 	// Process image in a goroutine
 	go func() {
+		// Animated GIFs get their own pipeline: image.Decode would silently
+		// flatten them to a single frame.
+		if isGIF(ext, buffer.Bytes()) {
+			path, err := optimizeGIF(buffer.Bytes(), destPath)
+			resultCh <- optimizeResult{path: path, err: err}
+			return
+		}
+
 		// Decode image
 		imgSrc, _, err := image.Decode(bytes.NewReader(buffer.Bytes()))
 		if err != nil {
-			resultCh <- struct {
-				path string
-				err  error
-			}{"", err}
+			resultCh <- optimizeResult{err: err}
 			return
 		}
-		
+
+		phash := computePHash(imgSrc)
+
 		// Resize image to max dimensions while preserving aspect ratio
 		// Reduced dimensions for faster processing
 		maxWidth := 800
 		maxHeight := 800
-		
+
 		// Use faster scaling algorithm (Box instead of Lanczos)
 		imgResized := imaging.Fit(imgSrc, maxWidth, maxHeight, imaging.Box)
-		
-		// Create destination file
-		out, err := os.Create(destPath)
-		if err != nil {
-			resultCh <- struct {
-				path string
-				err  error
-			}{"", err}
-			return
-		}
-		defer out.Close()
-		
+
+		// Encode to a buffer first since ObjectStorage.PutFile takes an
+		// io.Reader, not an io.Writer
+		encoded := new(bytes.Buffer)
+
 		// Encoding options based on file type - optimized for speed
 		switch strings.ToLower(ext) {
 		case ".jpg", ".jpeg":
 			// Lower quality for faster processing (80% instead of 85%)
-			err = jpeg.Encode(out, imgResized, &jpeg.Options{Quality: 80})
+			err = jpeg.Encode(encoded, imgResized, &jpeg.Options{Quality: 80})
 		case ".png":
 			// Use default compression for better speed instead of BestCompression
-			encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
-			err = encoder.Encode(out, imgResized)
+			pngEncoder := png.Encoder{CompressionLevel: png.DefaultCompression}
+			err = pngEncoder.Encode(encoded, imgResized)
 		default:
 			// Default to JPEG if not recognized
-			err = jpeg.Encode(out, imgResized, &jpeg.Options{Quality: 80})
+			err = jpeg.Encode(encoded, imgResized, &jpeg.Options{Quality: 80})
 		}
-		
+
 		if err != nil {
-			resultCh <- struct {
-				path string
-				err  error
-			}{"", err}
+			resultCh <- optimizeResult{err: err}
 			return
 		}
-		
-		resultCh <- struct {
-			path string
-			err  error
-		}{destPath, nil}
+
+		if err := storage.PutFile(destPath, bytes.NewReader(encoded.Bytes())); err != nil {
+			resultCh <- optimizeResult{err: err}
+			return
+		}
+
+		// Best-effort modern-format sidecars for content negotiation in serveUpload
+		writeSidecarVariants(imgResized, destPath)
+
+		resultCh <- optimizeResult{path: destPath, phash: phash}
 	}()
-	
+
 	// Wait for result with timeout
 	select {
 	case result := <-resultCh:
-		return result.path, result.err
+		return result.path, result.phash, result.err
 	case <-time.After(10 * time.Second):
-		return "", fmt.Errorf("image processing timed out")
+		return "", 0, fmt.Errorf("image processing timed out")
 	}
 }
\ No newline at end of file
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"net/http"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// dHashWidth/dHashHeight give 9x8 = 72 grayscale pixels, which reduce to
+// the 64 row-wise comparisons a dHash packs into a uint64.
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// computePHash computes a 64-bit perceptual hash of img using the standard
+// dHash algorithm: shrink to 9x8 grayscale, then for each row set bit i if
+// pixel[i] is brighter than pixel[i+1].
+func computePHash(img image.Image) uint64 {
+	small := imaging.Resize(img, dHashWidth, dHashHeight, imaging.Box)
+	gray := imaging.Grayscale(small)
+
+	var hash uint64
+	bit := 0
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			left := color.GrayModel.Convert(gray.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(gray.At(x+1, y)).(color.Gray).Y
+			if left > right {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// hammingDistance counts the differing bits between two perceptual hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// similarPHashThreshold is the maximum Hamming distance at which two images
+// are considered near-duplicates.
+const similarPHashThreshold = 5
+
+// similarPHashCandidateLimit bounds how many candidate rows
+// findSimilarProducts scans, so the check stays cheap as the catalog grows
+// instead of comparing against every product ever uploaded.
+const similarPHashCandidateLimit = 500
+
+// findSimilarProducts returns products whose PHash is within
+// similarPHashThreshold of hash, excluding excludeID. Only the most
+// recently created similarPHashCandidateLimit products are considered.
+func findSimilarProducts(hash uint64, excludeID uint) ([]Product, error) {
+	var candidates []Product
+	if err := db.Where("p_hash != 0 AND id != ?", excludeID).
+		Order("id DESC").
+		Limit(similarPHashCandidateLimit).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var similar []Product
+	for _, p := range candidates {
+		if hammingDistance(hash, p.PHash) <= similarPHashThreshold {
+			similar = append(similar, p)
+		}
+	}
+	return similar, nil
+}
+
+// getSimilarProducts handles GET /products/similar/:id, listing products
+// whose image is a near-duplicate of the given product's.
+func getSimilarProducts(c *gin.Context) {
+	id := c.Param("id")
+
+	var product Product
+	if err := db.First(&product, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	// A product with no image (or one still awaiting/failing optimization)
+	// has no hash to compare against - hammingDistance(0, p.PHash) would
+	// otherwise match it against any other hashless product, a false
+	// "similar" result with no shared image content behind it.
+	if product.PHash == 0 {
+		c.JSON(http.StatusOK, gin.H{"data": []Product{}})
+		return
+	}
+
+	similar, err := findSimilarProducts(product.PHash, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find similar products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": similar})
+}
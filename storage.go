@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ObjectStorage abstracts where uploaded files live so createProduct,
+// updateProduct, deleteProduct and optimizeImage don't need to know whether
+// a Product.ImagePath key resolves to a local file or an S3/MinIO object.
+type ObjectStorage interface {
+	// GetBucket returns the bucket (or root directory, for StorageFS) files
+	// are stored under.
+	GetBucket() string
+	// PutFile stores r under key, overwriting any existing object.
+	PutFile(key string, r io.Reader) error
+	// GetFile opens key for reading. Callers must close the returned reader.
+	GetFile(key string) (io.ReadCloser, error)
+	// DeleteFile removes key. Deleting a missing key is not an error.
+	DeleteFile(key string) error
+}
+
+// newObjectStorage selects a backend from the environment: MINIO_URL opts
+// into StorageMinio, otherwise uploads stay on local disk via StorageFS.
+func newObjectStorage() ObjectStorage {
+	if minioURL := getEnv("MINIO_URL", ""); minioURL != "" {
+		storage, err := newStorageMinio(minioURL)
+		if err != nil {
+			log.Fatalf("Failed to initialize MinIO storage: %v", err)
+		}
+		return storage
+	}
+
+	dir := getEnv("STORAGE_DIR", "./uploads")
+	return newStorageFS(dir)
+}
+
+// StorageFS is the local-disk ObjectStorage backend, preserving the
+// behavior the server had before pluggable storage existed.
+type StorageFS struct {
+	dir string
+}
+
+func newStorageFS(dir string) *StorageFS {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Failed to create storage directory: %v", err)
+	}
+	return &StorageFS{dir: dir}
+}
+
+func (s *StorageFS) GetBucket() string {
+	return s.dir
+}
+
+func (s *StorageFS) PutFile(key string, r io.Reader) error {
+	path := filepath.Join(s.dir, filepath.Clean("/"+key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, r)
+	return err
+}
+
+func (s *StorageFS) GetFile(key string) (io.ReadCloser, error) {
+	path := filepath.Join(s.dir, filepath.Clean("/"+key))
+	return os.Open(path)
+}
+
+func (s *StorageFS) DeleteFile(key string) error {
+	path := filepath.Join(s.dir, filepath.Clean("/"+key))
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// StorageMinio is the S3/MinIO ObjectStorage backend, selected by setting
+// MINIO_URL.
+type StorageMinio struct {
+	client *minio.Client
+	bucket string
+}
+
+func newStorageMinio(endpoint string) (*StorageMinio, error) {
+	user := getEnv("MINIO_USER", "minioadmin")
+	pass := getEnv("MINIO_PASS", "minioadmin")
+	bucket := getEnv("MINIO_BUCKET", "uploads")
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(user, pass, ""),
+		Secure: getEnv("MINIO_USE_SSL", "false") == "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &StorageMinio{client: client, bucket: bucket}, nil
+}
+
+func (s *StorageMinio) GetBucket() string {
+	return s.bucket
+}
+
+func (s *StorageMinio) PutFile(key string, r io.Reader) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, key, r, -1, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *StorageMinio) GetFile(key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// Confirm the key exists now so callers see a real error instead of one
+	// deferred until the first Read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *StorageMinio) DeleteFile(key string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tus.io resumable upload protocol support, so createProduct's single
+// multipart POST isn't the only way to get an image in: /uploads/tus lets
+// a flaky mobile client upload in chunks and resume after a drop.
+
+const (
+	tusResumableVersion = "1.0.0"
+	tusMaxSize          = 500 << 20 // 500 MiB
+)
+
+var tusDir = "./uploads/.tus"
+
+// tusUpload is the JSON metadata sidecar persisted next to each partial
+// upload under tusDir.
+type tusUpload struct {
+	ID          string            `json:"id"`
+	TotalLength int64             `json:"total_length"`
+	Offset      int64             `json:"offset"`
+	Mime        string            `json:"mime"`
+	Fields      map[string]string `json:"fields"`
+}
+
+func tusDataPath(id string) string { return filepath.Join(tusDir, id) }
+func tusMetaPath(id string) string { return filepath.Join(tusDir, id+".json") }
+
+// validTusID reports whether id is a well-formed UUID, the only shape
+// tusCreate ever hands out. StorageFS confines its keys with
+// filepath.Clean, and job IDs are only ever map keys, never paths;
+// tusDataPath/tusMetaPath build a path directly from the
+// :id route param, so without this check a value like ".." would resolve
+// outside tusDir.
+func validTusID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+func loadTusUpload(id string) (*tusUpload, error) {
+	data, err := os.ReadFile(tusMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var u tusUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (u *tusUpload) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusMetaPath(u.ID), data, 0644)
+}
+
+// setTusHeaders applies the headers every tus response must carry.
+func setTusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusResumableVersion)
+}
+
+// tusOptions answers the protocol discovery preflight.
+func tusOptions(c *gin.Context) {
+	setTusHeaders(c)
+	c.Header("Tus-Version", tusResumableVersion)
+	c.Header("Tus-Max-Size", strconv.Itoa(tusMaxSize))
+	c.Status(http.StatusNoContent)
+}
+
+// tusCreate handles POST /uploads/tus, starting a new resumable upload.
+func tusCreate(c *gin.Context) {
+	setTusHeaders(c)
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if length > tusMaxSize {
+		c.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := os.MkdirAll(tusDir, 0755); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	fields := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	id := uuid.New().String()
+
+	if err := os.WriteFile(tusDataPath(id), nil, 0644); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	upload := &tusUpload{
+		ID:          id,
+		TotalLength: length,
+		Offset:      0,
+		Mime:        fields["filetype"],
+		Fields:      fields,
+	}
+	if err := upload.save(); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Location", "/uploads/tus/"+id)
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+// tusHead handles HEAD /uploads/tus/:id, reporting how much has been
+// received so far so a client can resume from the right offset.
+func tusHead(c *gin.Context) {
+	setTusHeaders(c)
+
+	id := c.Param("id")
+	if !validTusID(id) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.TotalLength, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// tusPatch handles PATCH /uploads/tus/:id, appending one chunk and, once
+// the upload is complete, running it through the existing image pipeline.
+func tusPatch(c *gin.Context) {
+	setTusHeaders(c)
+
+	if c.GetHeader("Content-Type") != "application/offset+octet-stream" {
+		c.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := c.Param("id")
+	if !validTusID(id) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if offset != upload.Offset {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(tusDataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	written, err := writeAtMost(f, c.Request.Body, upload.TotalLength-offset)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	upload.Offset += written
+	if err := upload.save(); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+
+	if upload.Offset >= upload.TotalLength {
+		productID, jobID, err := finishTusUpload(upload)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("X-Product-Id", strconv.FormatUint(uint64(productID), 10))
+		c.Header("X-Job-Id", jobID)
+		os.Remove(tusDataPath(id))
+		os.Remove(tusMetaPath(id))
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// finishTusUpload moves a completed tus upload into storage, creates its
+// Product, and enqueues the same optimization job createProduct uses.
+func finishTusUpload(upload *tusUpload) (uint, string, error) {
+	ext := filepath.Ext(upload.Fields["filename"])
+	if ext == "" {
+		ext = ".jpg"
+	}
+	key := uuid.New().String() + ext
+
+	f, err := os.Open(tusDataPath(upload.ID))
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	if err := storage.PutFile(key, f); err != nil {
+		return 0, "", err
+	}
+
+	price, _ := strconv.ParseFloat(upload.Fields["price"], 64)
+	product := Product{
+		Name:        upload.Fields["name"],
+		Description: upload.Fields["description"],
+		Price:       price,
+		ImagePath:   key,
+		ImageStatus: string(JobPending),
+	}
+	if err := db.Create(&product).Error; err != nil {
+		return 0, "", err
+	}
+
+	jobID := newJobID()
+	enqueueImageJob(ImageJob{ID: jobID, SrcKey: key, DestKey: key, Ext: ext, ProductID: product.ID})
+
+	return product.ID, jobID, nil
+}
+
+// parseTusMetadata decodes the tus Upload-Metadata header: comma-separated
+// "key base64(value)" pairs.
+func parseTusMetadata(header string) map[string]string {
+	fields := make(map[string]string)
+	if header == "" {
+		return fields
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		fields[parts[0]] = string(decoded)
+	}
+	return fields
+}
+
+// writeAtMost copies from r to w, stopping at limit bytes so a malformed
+// client can't write past the declared Upload-Length.
+func writeAtMost(w io.Writer, r io.Reader, limit int64) (int64, error) {
+	n, err := io.Copy(w, io.LimitReader(r, limit))
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// variantCache is a keyed, size-bounded disk cache for on-the-fly image
+// variants (GET /uploads/:filename?w=...&h=...&fit=...&q=...&fmt=...).
+// Entries are evicted least-recently-used first once totalBytes exceeds
+// maxBytes.
+type variantCache struct {
+	mu         sync.Mutex
+	dir        string
+	maxBytes   int64
+	totalBytes int64
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+func newVariantCache(dir string, maxBytes int64) *variantCache {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Failed to create variant cache directory: %v", err)
+	}
+
+	c := &variantCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+	c.loadExisting()
+	return c
+}
+
+// loadExisting rebuilds the LRU index from whatever is already on disk,
+// oldest-modified first, so a restart doesn't forget eviction order.
+func (c *variantCache) loadExisting() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	type fileInfo struct {
+		name    string
+		size    int64
+		modTime int64
+	}
+	var files []fileInfo
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fileInfo{name: e.Name(), size: info.Size(), modTime: info.ModTime().UnixNano()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+
+	for _, f := range files {
+		elem := c.order.PushFront(&cacheEntry{key: f.name, size: f.size})
+		c.items[f.name] = elem
+		c.totalBytes += f.size
+	}
+	c.evictLocked()
+}
+
+// cacheKey hashes the original key plus request params into a single
+// filesystem-safe filename.
+func cacheKey(originalKey string, w, h int, fit string, q int, fmtExt string) string {
+	h64 := fnv.New64a()
+	fmt.Fprintf(h64, "%s|%d|%d|%s|%d|%s", originalKey, w, h, fit, q, fmtExt)
+	return fmt.Sprintf("%x%s", h64.Sum64(), fmtExt)
+}
+
+// Get returns the cache file path for key if present, marking it most
+// recently used.
+func (c *variantCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return filepath.Join(c.dir, key), true
+}
+
+// Put writes data under key, evicting least-recently-used entries until the
+// cache is back under its size cap.
+func (c *variantCache) Put(key string, data []byte) (string, error) {
+	path := filepath.Join(c.dir, key)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.totalBytes += int64(len(data)) - entry.size
+		entry.size = int64(len(data))
+		c.order.MoveToFront(elem)
+	} else {
+		elem := c.order.PushFront(&cacheEntry{key: key, size: int64(len(data))})
+		c.items[key] = elem
+		c.totalBytes += int64(len(data))
+	}
+
+	c.evictLocked()
+	return path, nil
+}
+
+// evictLocked must be called with c.mu held.
+func (c *variantCache) evictLocked() {
+	for c.totalBytes > c.maxBytes {
+		back := c.order.Back()
+		if back == nil {
+			return
+		}
+		entry := back.Value.(*cacheEntry)
+		os.Remove(filepath.Join(c.dir, entry.key))
+		c.order.Remove(back)
+		delete(c.items, entry.key)
+		c.totalBytes -= entry.size
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// variantParams are the query parameters GET /uploads/:filename accepts to
+// request a resized/re-encoded variant instead of the stored original.
+type variantParams struct {
+	width, height int
+	fit           string
+	quality       int
+	format        string
+}
+
+const (
+	defaultVariantQuality  = 75
+	variantCacheMaxBytes   = 500 << 20 // 500 MiB
+	variantCacheDirDefault = "./uploads/.variants"
+)
+
+// variants is initialized in main, once the storage dir layout is known,
+// the same way storage itself is.
+var (
+	variants   *variantCache
+	variantsSF singleflight.Group
+)
+
+// hasVariantParams reports whether the request is asking for an on-the-fly
+// variant rather than the stored original.
+func hasVariantParams(c *gin.Context) bool {
+	q := c.Request.URL.Query()
+	return q.Has("w") || q.Has("h") || q.Has("fit") || q.Has("q") || q.Has("fmt")
+}
+
+func parseVariantParams(c *gin.Context, originalExt string) variantParams {
+	p := variantParams{
+		fit:     c.DefaultQuery("fit", "contain"),
+		format:  strings.TrimPrefix(strings.ToLower(c.DefaultQuery("fmt", strings.TrimPrefix(originalExt, "."))), "."),
+		quality: defaultVariantQuality,
+	}
+	if w, err := strconv.Atoi(c.Query("w")); err == nil && w > 0 {
+		p.width = w
+	}
+	if h, err := strconv.Atoi(c.Query("h")); err == nil && h > 0 {
+		p.height = h
+	}
+	if q, err := strconv.Atoi(c.Query("q")); err == nil && q > 0 && q <= 100 {
+		p.quality = q
+	}
+	return p
+}
+
+// serveImageVariant generates (or serves from cache) a resized/re-encoded
+// variant of the original stored under key. Concurrent requests for the
+// same variant are collapsed into a single decode via variantsSF.
+func serveImageVariant(c *gin.Context, key string) {
+	ext := extOf(key)
+	params := parseVariantParams(c, ext)
+	cKey := cacheKey(key, params.width, params.height, params.fit, params.quality, "."+params.format)
+
+	if path, ok := variants.Get(cKey); ok {
+		c.Header("X-Variant-Cache", "hit")
+		c.File(path)
+		return
+	}
+
+	result, err, _ := variantsSF.Do(cKey, func() (interface{}, error) {
+		return buildVariant(key, cKey, params)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build image variant"})
+		return
+	}
+
+	c.Header("X-Variant-Cache", "miss")
+	c.File(result.(string))
+}
+
+func buildVariant(key, cKey string, params variantParams) (string, error) {
+	src, err := storage.GetFile(key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return "", err
+	}
+
+	if params.width > 0 || params.height > 0 {
+		switch params.fit {
+		case "cover":
+			img = imaging.Fill(img, nonZero(params.width, params.height), nonZero(params.height, params.width), imaging.Center, imaging.Box)
+		default: // "contain"
+			img = imaging.Fit(img, nonZero(params.width, params.height), nonZero(params.height, params.width), imaging.Box)
+		}
+	}
+
+	encoded := new(bytes.Buffer)
+	switch params.format {
+	case "webp":
+		err = webp.Encode(encoded, img, &webp.Options{Quality: float32(params.quality)})
+	case "png":
+		err = png.Encode(encoded, img)
+	default:
+		err = jpeg.Encode(encoded, img, &jpeg.Options{Quality: params.quality})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return variants.Put(cKey, encoded.Bytes())
+}
+
+// nonZero returns v if it's set, otherwise fallback - used so a caller that
+// only specified w (or only h) still gets a proportionate bound on the
+// other axis.
+func nonZero(v, fallback int) int {
+	if v > 0 {
+		return v
+	}
+	return fallback
+}
+
+func extOf(key string) string {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		return key[i:]
+	}
+	return ""
+}